@@ -2,19 +2,238 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/quic-go/quic-go/http3"
 	"golang.org/x/net/dns/dnsmessage"
 )
 
-// queryDoH向指定的DoH服务器发送DNS查询。
-func queryDoH(serverURL string, domainName string, queryType dnsmessage.Type) (*dnsmessage.Message, error) {
-	// 1. 使用 dnsmessage 构建 DNS 查询消息
+// qtypeByName 支持在命令行中通过记录类型名称（而非数字）指定查询类型。
+var qtypeByName = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"MX":    dnsmessage.TypeMX,
+	"CNAME": dnsmessage.TypeCNAME,
+	"NS":    dnsmessage.TypeNS,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+// qnameByType是qtypeByName的反向映射，用于把dnsmessage.Type还原为RR
+// 助记符（"A"、"MX"……）。dnsmessage.Type.String()返回的是Go常量名
+// （如"TypeA"），并非DNS助记符，不能直接用在协议层面。
+var qnameByType = invertQtypeByName()
+
+func invertQtypeByName() map[dnsmessage.Type]string {
+	m := make(map[dnsmessage.Type]string, len(qtypeByName))
+	for name, qtype := range qtypeByName {
+		m[qtype] = name
+	}
+	return m
+}
+
+// qtypeMnemonic返回某个记录类型对应的RR助记符（"A"、"MX"等），用于
+// 拼接JSON DoH的"type"查询参数。对于qnameByType中没有的类型，回退到
+// 十进制数字形式，这也是JSON DoH API接受的格式。
+func qtypeMnemonic(qtype dnsmessage.Type) string {
+	if name, ok := qnameByType[qtype]; ok {
+		return name
+	}
+	return strconv.Itoa(int(qtype))
+}
+
+// parseQType将字符串形式的记录类型名称解析为dnsmessage.Type。
+func parseQType(name string) (dnsmessage.Type, error) {
+	qtype, ok := qtypeByName[strings.ToUpper(name)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported record type: %s", name)
+	}
+	return qtype, nil
+}
+
+// parseQTypes解析以逗号分隔的记录类型列表，例如"A,AAAA,MX"。
+func parseQTypes(names string) ([]dnsmessage.Type, error) {
+	var qtypes []dnsmessage.Type
+	for _, name := range strings.Split(names, ",") {
+		qtype, err := parseQType(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		qtypes = append(qtypes, qtype)
+	}
+	return qtypes, nil
+}
+
+// buildDoHGetURL按照RFC 8484 §4.1的要求，将查询消息的wire格式编码为
+// base64url（无填充）字符串，并拼接到serverURL上，得到GET请求使用的URL。
+// 如果serverURL中包含URI模板占位符"{?dns}"，则替换该占位符；否则根据
+// serverURL是否已经带有查询字符串，选择追加"?dns="或"&dns="。
+func buildDoHGetURL(serverURL string, queryWire []byte) (string, error) {
+	encodedQuery := base64.RawURLEncoding.EncodeToString(queryWire)
+
+	if strings.Contains(serverURL, "{?dns}") {
+		return strings.Replace(serverURL, "{?dns}", "?dns="+encodedQuery, 1), nil
+	}
+
+	parsedURL, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid server URL '%s': %w", serverURL, err)
+	}
+
+	separator := "?"
+	if parsedURL.RawQuery != "" {
+		separator = "&"
+	}
+	return serverURL + separator + "dns=" + encodedQuery, nil
+}
+
+// ednsOpts描述附加在查询消息上的EDNS0（RFC 6891）选项。UDPSize写入
+// OPT伪记录的Class字段，充当通告的UDP payload大小；DNSSECOK对应OPT
+// 扩展flags中的DO位（RFC 3225），请求服务器返回DNSSEC相关记录；
+// ClientSubnet在非nil时携带EDNS Client Subnet（RFC 7871）提示。
+type ednsOpts struct {
+	UDPSize      uint16
+	DNSSECOK     bool
+	ClientSubnet *net.IPNet
+}
+
+// ecsOptionCode是RFC 7871定义的EDNS Client Subnet选项代码。
+const ecsOptionCode = 8
+
+// buildECSOption将一个IP前缀编码为RFC 7871 §6描述的EDNS Client
+// Subnet选项：FAMILY(2字节) + SOURCE PREFIX-LENGTH(1字节) +
+// SCOPE PREFIX-LENGTH(1字节，查询中固定为0) + 按前缀长度截断的地址。
+func buildECSOption(subnet *net.IPNet) (dnsmessage.Option, error) {
+	sourcePrefixLen, totalBits := subnet.Mask.Size()
+
+	var family uint16
+	var ip net.IP
+	switch totalBits {
+	case 32:
+		family = 1
+		ip = subnet.IP.To4()
+	case 128:
+		family = 2
+		ip = subnet.IP.To16()
+	default:
+		return dnsmessage.Option{}, fmt.Errorf("unsupported client subnet mask size: %d bits", totalBits)
+	}
+	if ip == nil {
+		return dnsmessage.Option{}, fmt.Errorf("invalid client subnet address: %s", subnet.IP)
+	}
+
+	addrLen := (sourcePrefixLen + 7) / 8
+	data := make([]byte, 4+addrLen)
+	data[0] = byte(family >> 8)
+	data[1] = byte(family)
+	data[2] = byte(sourcePrefixLen)
+	data[3] = 0 // scope prefix-length 在查询中必须为0
+	copy(data[4:], ip[:addrLen])
+
+	return dnsmessage.Option{Code: ecsOptionCode, Data: data}, nil
+}
+
+// buildOPTResource根据ednsOpts构建附加区的OPT伪记录。DO位被打包进
+// TTL字段的扩展flags部分（RFC 6891 §6.1.3），因为dnsmessage包把
+// OPT记录的TTL当作原始的32位扩展flags/RCODE/version字段处理。
+func buildOPTResource(opts ednsOpts) (dnsmessage.Resource, error) {
+	root, err := dnsmessage.NewName(".")
+	if err != nil {
+		return dnsmessage.Resource{}, fmt.Errorf("failed to build OPT owner name: %w", err)
+	}
+
+	var extFlags uint32
+	if opts.DNSSECOK {
+		extFlags |= 0x8000 // DO位
+	}
+
+	optBody := &dnsmessage.OPTResource{}
+	if opts.ClientSubnet != nil {
+		ecsOption, err := buildECSOption(opts.ClientSubnet)
+		if err != nil {
+			return dnsmessage.Resource{}, err
+		}
+		optBody.Options = append(optBody.Options, ecsOption)
+	}
+
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  root,
+			Type:  dnsmessage.TypeOPT,
+			Class: dnsmessage.Class(opts.UDPSize),
+			TTL:   extFlags,
+		},
+		Body: optBody,
+	}, nil
+}
+
+// buildTLSConfig根据命令行提供的选项构造发起DoH连接使用的*tls.Config。
+// serverName用于设置SNI；caFile非空时从PEM文件加载自定义的RootCAs；
+// pinsSHA256非空时安装一个VerifyPeerCertificate回调，只要有一张对端
+// 证书的SPKI（SubjectPublicKeyInfo）SHA-256摘要匹配其中一个base64
+// pin就放行，否则握手失败。insecure仅用于显式放弃证书校验的场景，
+// 默认必须为false，即启用正常校验。
+func buildTLSConfig(serverName string, caFile string, pinsSHA256 []string, insecure bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecure,
+	}
+
+	if caFile != "" {
+		pemBytes, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file '%s': %w", caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("no valid certificates found in CA file '%s'", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if len(pinsSHA256) > 0 {
+		pins := make(map[string]struct{}, len(pinsSHA256))
+		for _, pin := range pinsSHA256 {
+			pins[pin] = struct{}{}
+		}
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			for _, rawCert := range rawCerts {
+				cert, err := x509.ParseCertificate(rawCert)
+				if err != nil {
+					continue
+				}
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if _, ok := pins[base64.StdEncoding.EncodeToString(sum[:])]; ok {
+					return nil
+				}
+			}
+			return fmt.Errorf("no presented certificate matched any configured SPKI pin")
+		}
+	}
+
+	return cfg, nil
+}
+
+// buildDoHRequest使用dnsmessage构建DNS查询消息并打包为wire格式，
+// 然后根据method（"POST"或"GET"，不区分大小写）构造对应的*http.Request。
+// edns非nil时，会在Additional区附加一条OPT伪记录，用于携带EDNS0、
+// DNSSEC（DO位）和EDNS Client Subnet提示。
+func buildDoHRequest(serverURL string, domainName string, queryType dnsmessage.Type, method string, edns *ednsOpts) (*http.Request, error) {
 	// 确保域名以点结尾 (FQDN)
 	if domainName[len(domainName)-1] != '.' {
 		domainName += "."
@@ -44,163 +263,588 @@ func queryDoH(serverURL string, domainName string, queryType dnsmessage.Type) (*
 		Questions: []dnsmessage.Question{question},
 	}
 
+	// 如果调用方请求了EDNS0，附加OPT伪记录
+	if edns != nil {
+		optResource, err := buildOPTResource(*edns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build EDNS0 OPT resource: %w", err)
+		}
+		msg.Additionals = append(msg.Additionals, optResource)
+	}
+
 	// 将查询消息打包为 wire 格式 (二进制数据)
 	queryWire, err := msg.Pack()
 	if err != nil {
 		return nil, fmt.Errorf("failed to pack DNS query: %w", err)
 	}
 
-	// 2. 准备 HTTP POST 请求
-	req, err := http.NewRequest(http.MethodPost, serverURL, bytes.NewReader(queryWire))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	// 根据method准备HTTP请求
+	var req *http.Request
+	switch strings.ToUpper(method) {
+	case "", "POST":
+		req, err = http.NewRequest(http.MethodPost, serverURL, bytes.NewReader(queryWire))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/dns-message")
+	case "GET":
+		getURL, buildErr := buildDoHGetURL(serverURL, queryWire)
+		if buildErr != nil {
+			return nil, buildErr
+		}
+		req, err = http.NewRequest(http.MethodGet, getURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported method '%s': must be GET or POST", method)
 	}
 
-	// 设置 HTTP 请求头
 	req.Header.Set("Accept", "application/dns-message")
-	req.Header.Set("Content-Type", "application/dns-message")
-
-	// 3. 发送 HTTPS POST 请求
-	// 创建带超时的 HTTP 客户端，并忽略证书验证（仅用于测试）
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-
-	client := &http.Client{
-		Timeout:   10 * time.Second, // 设置超时
-		Transport: transport,
-	}
+	return req, nil
+}
 
-	log.Printf("Sending query to %s: %s (%s)\n", serverURL, domainName, queryType.String())
+// doDoHRequest使用client发送已经构建好的DoH请求，校验HTTP状态码和
+// Content-Type，并把响应体解析回dnsmessage.Message。日志中会记录
+// 协商得到的ALPN协议和本次请求的往返耗时，便于比较HTTP/1.1、HTTP/2
+// 和HTTP/3几种传输方式。alpnOverride非空时直接作为ALPN打印，这是因为
+// quic-go/http3的RoundTripper不会填充http.Response.TLS，无法从
+// resp.TLS读出协商结果；调用方在已知走的是HTTP/3时传入"h3"。
+// alpnOverride为空则退化为从resp.TLS.NegotiatedProtocol读取。
+func doDoHRequest(client *http.Client, req *http.Request, domainName string, queryType dnsmessage.Type, edns *ednsOpts, alpnOverride string) (*dnsmessage.Message, error) {
+	log.Printf("Sending %s query to %s: %s (%s)\n", req.Method, req.URL.String(), domainName, queryType.String())
+	start := time.Now()
 	resp, err := client.Do(req)
+	rtt := time.Since(start)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute HTTP request: %w", err)
 	}
 	// 确保在函数结束时关闭响应体
 	defer resp.Body.Close()
 
-	// 4. 检查 HTTP 响应状态码
+	// 检查 HTTP 响应状态码
 	if resp.StatusCode != http.StatusOK {
 		// 尝试读取响应体以获取更多错误信息
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("DoH server returned unexpected status code %d: %s", resp.StatusCode, string(bodyBytes))
 	}
 
-	// 5. 检查响应的内容类型
+	// 检查响应的内容类型
 	contentType := resp.Header.Get("Content-Type")
 	if contentType != "application/dns-message" {
 		return nil, fmt.Errorf("DoH server returned unexpected Content-Type: %s", contentType)
 	}
 
-	// 6. 读取并解析响应体中的 DNS 消息
+	// 读取并解析响应体中的 DNS 消息
 	responseWire, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read HTTP response body: %w", err)
 	}
 
 	var responseMsg dnsmessage.Message
-	err = responseMsg.Unpack(responseWire)
-	if err != nil {
+	if err := responseMsg.Unpack(responseWire); err != nil {
 		return nil, fmt.Errorf("failed to parse DNS response: %w", err)
 	}
 
-	log.Printf("Received response from %s\n", serverURL)
+	alpn := alpnOverride
+	if alpn == "" {
+		alpn = "unknown"
+		if resp.TLS != nil && resp.TLS.NegotiatedProtocol != "" {
+			alpn = resp.TLS.NegotiatedProtocol
+		}
+	}
+	log.Printf("Received response from %s via ALPN=%s in %s\n", req.URL.Host, alpn, rtt)
+	if edns != nil && edns.DNSSECOK {
+		if responseMsg.Header.AuthenticData {
+			log.Printf("DNSSEC: response is authenticated (AD=1)\n")
+		} else {
+			log.Printf("DNSSEC: response is NOT authenticated (AD=0)\n")
+		}
+	}
 	return &responseMsg, nil
 }
 
-// --- 示例用法 ---
-func main() {
-	// 标准路由
-	dohServer := "http://localhost:8080/dns-query"
-	domainToQuery := "www.example.com"
+// queryDoH向指定的DoH服务器发送DNS查询。method决定使用的HTTP方法，
+// 取值为"POST"（application/dns-message请求体）或"GET"（RFC 8484
+// §4.1描述的?dns=<base64url>查询参数），不区分大小写。timeout控制HTTP
+// 请求的最长等待时间，tlsConfig（由buildTLSConfig构造）控制证书校验、
+// SNI和SPKI pinning行为。edns非nil时会附加EDNS0 OPT记录。底层固定
+// 使用HTTP/1.1或HTTP/2传输；需要HTTP/3时请使用queryDoHWithTransport。
+func queryDoH(serverURL string, domainName string, queryType dnsmessage.Type, method string, timeout time.Duration, tlsConfig *tls.Config, edns *ednsOpts) (*dnsmessage.Message, error) {
+	return queryDoHWithTransport(serverURL, domainName, queryType, method, timeout, tlsConfig, edns, false)
+}
 
-	// --- 查询 A 记录 ---
-	fmt.Printf("\n--- Querying A Record (%s) ---\n", domainToQuery)
-	aResponse, err := queryDoH(dohServer, domainToQuery, dnsmessage.TypeA)
-	if err != nil {
-		log.Printf("Failed to query A record: %v\n", err)
-	} else {
-		fmt.Println("Raw Response Header:", aResponse.Header) // 打印响应头信息
-		fmt.Println("Parsed Results (Answer Section):")
-		if len(aResponse.Answers) > 0 {
-			for _, answer := range aResponse.Answers {
-				// 根据记录类型处理不同的资源记录
-				switch rr := answer.Body.(type) {
-				case *dnsmessage.AResource:
-					fmt.Printf("A Record: %v.%v.%v.%v\n", rr.A[0], rr.A[1], rr.A[2], rr.A[3])
-				default:
-					fmt.Printf("Unknown record type: %T\n", rr)
-				}
-			}
-		} else {
-			fmt.Println("No A records found.")
+// queryDoHWithTransport与queryDoH相同，但额外接受useHTTP3参数。为true
+// 时，使用github.com/quic-go/quic-go/http3构造的http3.RoundTripper
+// 发起DoH3（RFC 9230）请求；如果QUIC连接失败，会记录原因并自动回退
+// 到常规的HTTP/2传输重试一次。
+func queryDoHWithTransport(serverURL string, domainName string, queryType dnsmessage.Type, method string, timeout time.Duration, tlsConfig *tls.Config, edns *ednsOpts, useHTTP3 bool) (*dnsmessage.Message, error) {
+	if useHTTP3 {
+		req, err := buildDoHRequest(serverURL, domainName, queryType, method, edns)
+		if err != nil {
+			return nil, err
+		}
+		h3Transport := &http3.RoundTripper{
+			TLSClientConfig: tlsConfig,
+		}
+		defer h3Transport.Close()
+
+		resp, err := doDoHRequest(&http.Client{Timeout: timeout, Transport: h3Transport}, req, domainName, queryType, edns, "h3")
+		if err == nil {
+			return resp, nil
 		}
+		log.Printf("HTTP/3 query failed, falling back to HTTP/2: %v\n", err)
 	}
 
-	// --- 查询 AAAA 记录 ---
-	fmt.Printf("\n--- Querying AAAA Record (%s) ---\n", domainToQuery)
-	aaaaResponse, err := queryDoH(dohServer, domainToQuery, dnsmessage.TypeAAAA)
+	req, err := buildDoHRequest(serverURL, domainName, queryType, method, edns)
 	if err != nil {
-		log.Printf("Failed to query AAAA record: %v\n", err)
-	} else {
-		fmt.Println("Parsed Results (Answer Section):")
-		if len(aaaaResponse.Answers) > 0 {
-			for _, answer := range aaaaResponse.Answers {
-				switch rr := answer.Body.(type) {
-				case *dnsmessage.AAAAResource:
-					fmt.Printf("AAAA Record: %x:%x:%x:%x:%x:%x:%x:%x\n",
-						rr.AAAA[0:2], rr.AAAA[2:4], rr.AAAA[4:6], rr.AAAA[6:8],
-						rr.AAAA[8:10], rr.AAAA[10:12], rr.AAAA[12:14], rr.AAAA[14:16])
-				default:
-					fmt.Printf("Unknown record type: %T\n", rr)
-				}
-			}
-		} else {
-			fmt.Println("No AAAA records found.")
+		return nil, err
+	}
+	transport := &http.Transport{
+		TLSClientConfig:   tlsConfig,
+		ForceAttemptHTTP2: true,
+	}
+	return doDoHRequest(&http.Client{Timeout: timeout, Transport: transport}, req, domainName, queryType, edns, "")
+}
+
+// dohJSONQuestion对应JSON DoH响应中的Question数组元素。
+type dohJSONQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+// dohJSONRR对应JSON DoH响应中Answer/Authority数组的记录元素。
+type dohJSONRR struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// dohJSONResponse对应Cloudflare（application/dns-json）和Google
+// （application/x-javascript）共用的JSON DoH响应结构。
+type dohJSONResponse struct {
+	Status   int               `json:"Status"`
+	TC       bool              `json:"TC"`
+	RD       bool              `json:"RD"`
+	RA       bool              `json:"RA"`
+	AD       bool              `json:"AD"`
+	CD       bool              `json:"CD"`
+	Question []dohJSONQuestion `json:"Question"`
+	Answer   []dohJSONRR       `json:"Answer"`
+}
+
+// queryDoHJSON向指定的DoH服务器发送Google/Cloudflare风格的JSON查询，
+// 并将JSON响应转换回dnsmessage.Message，使调用方可以像处理wire格式
+// 响应一样处理结果。这对于只实现了JSON方言、不支持RFC 8484 wire
+// 格式的服务器很有用。
+func queryDoHJSON(serverURL string, domainName string, qtype dnsmessage.Type, timeout time.Duration, tlsConfig *tls.Config) (*dnsmessage.Message, error) {
+	query := url.Values{}
+	query.Set("name", domainName)
+	query.Set("type", qtypeMnemonic(qtype))
+	query.Set("cd", "0")
+	query.Set("do", "0")
+	query.Set("ct", "application/dns-json")
+
+	req, err := http.NewRequest(http.MethodGet, serverURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Accept", "application/dns-json")
+
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+
+	log.Printf("Sending JSON query to %s: %s (%s)\n", serverURL, domainName, qtype.String())
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("DoH server returned unexpected status code %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HTTP response body: %w", err)
+	}
+
+	var jsonResp dohJSONResponse
+	if err := json.Unmarshal(bodyBytes, &jsonResp); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON DoH response: %w", err)
+	}
+
+	log.Printf("Received JSON response from %s (AD=%v, CD=%v)\n", serverURL, jsonResp.AD, jsonResp.CD)
+	return jsonToDNSMessage(jsonResp)
+}
+
+// jsonToDNSMessage将已解析的JSON DoH响应转换为dnsmessage.Message。
+func jsonToDNSMessage(jsonResp dohJSONResponse) (*dnsmessage.Message, error) {
+	msg := &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			Response:           true,
+			Truncated:          jsonResp.TC,
+			RecursionDesired:   jsonResp.RD,
+			RecursionAvailable: jsonResp.RA,
+			AuthenticData:      jsonResp.AD,
+			CheckingDisabled:   jsonResp.CD,
+			RCode:              dnsmessage.RCode(jsonResp.Status),
+		},
+	}
+
+	for _, q := range jsonResp.Question {
+		name, err := dnsmessage.NewName(ensureFQDN(q.Name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid question name '%s': %w", q.Name, err)
+		}
+		msg.Questions = append(msg.Questions, dnsmessage.Question{
+			Name:  name,
+			Type:  dnsmessage.Type(q.Type),
+			Class: dnsmessage.ClassINET,
+		})
+	}
+
+	for _, a := range jsonResp.Answer {
+		resource, err := jsonRRToResource(a)
+		if err != nil {
+			log.Printf("skipping answer record for %s: %v\n", a.Name, err)
+			continue
 		}
+		msg.Answers = append(msg.Answers, resource)
+	}
+
+	return msg, nil
+}
+
+// ensureFQDN确保域名以点结尾，这是dnsmessage.NewName的要求。
+func ensureFQDN(domainName string) string {
+	if domainName == "" || domainName[len(domainName)-1] == '.' {
+		return domainName
 	}
+	return domainName + "."
+}
 
-	// --- 查询 MX 记录 ---
-	fmt.Printf("\n--- Querying MX Record (google.com) ---\n")
-	mxResponse, err := queryDoH(dohServer, "google.com", dnsmessage.TypeMX)
+// jsonRRToResource将一条JSON DoH应答记录转换为dnsmessage.Resource，
+// 支持A、AAAA、CNAME、MX、TXT、NS、SOA、PTR和SRV。
+func jsonRRToResource(rr dohJSONRR) (dnsmessage.Resource, error) {
+	name, err := dnsmessage.NewName(ensureFQDN(rr.Name))
 	if err != nil {
-		log.Printf("Failed to query MX record: %v\n", err)
-	} else {
-		fmt.Println("Parsed Results (Answer Section):")
-		if len(mxResponse.Answers) > 0 {
-			for _, answer := range mxResponse.Answers {
-				switch rr := answer.Body.(type) {
-				case *dnsmessage.MXResource:
-					fmt.Printf("MX Record: Priority=%d, Server=%s\n", rr.Pref, rr.MX.String())
-				default:
-					fmt.Printf("Unknown record type: %T\n", rr)
-				}
+		return dnsmessage.Resource{}, fmt.Errorf("invalid name '%s': %w", rr.Name, err)
+	}
+	header := dnsmessage.ResourceHeader{
+		Name:  name,
+		Type:  dnsmessage.Type(rr.Type),
+		Class: dnsmessage.ClassINET,
+		TTL:   rr.TTL,
+	}
+
+	var body dnsmessage.ResourceBody
+	switch dnsmessage.Type(rr.Type) {
+	case dnsmessage.TypeA:
+		ip := net.ParseIP(rr.Data).To4()
+		if ip == nil {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid A data '%s'", rr.Data)
+		}
+		var addr [4]byte
+		copy(addr[:], ip)
+		body = &dnsmessage.AResource{A: addr}
+	case dnsmessage.TypeAAAA:
+		ip := net.ParseIP(rr.Data).To16()
+		if ip == nil {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid AAAA data '%s'", rr.Data)
+		}
+		var addr [16]byte
+		copy(addr[:], ip)
+		body = &dnsmessage.AAAAResource{AAAA: addr}
+	case dnsmessage.TypeCNAME:
+		target, err := dnsmessage.NewName(ensureFQDN(rr.Data))
+		if err != nil {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid CNAME data '%s': %w", rr.Data, err)
+		}
+		body = &dnsmessage.CNAMEResource{CNAME: target}
+	case dnsmessage.TypeNS:
+		target, err := dnsmessage.NewName(ensureFQDN(rr.Data))
+		if err != nil {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid NS data '%s': %w", rr.Data, err)
+		}
+		body = &dnsmessage.NSResource{NS: target}
+	case dnsmessage.TypePTR:
+		target, err := dnsmessage.NewName(ensureFQDN(rr.Data))
+		if err != nil {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid PTR data '%s': %w", rr.Data, err)
+		}
+		body = &dnsmessage.PTRResource{PTR: target}
+	case dnsmessage.TypeMX:
+		fields := strings.Fields(rr.Data)
+		if len(fields) != 2 {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid MX data '%s'", rr.Data)
+		}
+		pref, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid MX preference in '%s': %w", rr.Data, err)
+		}
+		target, err := dnsmessage.NewName(ensureFQDN(fields[1]))
+		if err != nil {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid MX exchange in '%s': %w", rr.Data, err)
+		}
+		body = &dnsmessage.MXResource{Pref: uint16(pref), MX: target}
+	case dnsmessage.TypeTXT:
+		body = &dnsmessage.TXTResource{TXT: []string{strings.Trim(rr.Data, `"`)}}
+	case dnsmessage.TypeSRV:
+		fields := strings.Fields(rr.Data)
+		if len(fields) != 4 {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid SRV data '%s'", rr.Data)
+		}
+		priority, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid SRV priority in '%s': %w", rr.Data, err)
+		}
+		weight, err := strconv.ParseUint(fields[1], 10, 16)
+		if err != nil {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid SRV weight in '%s': %w", rr.Data, err)
+		}
+		port, err := strconv.ParseUint(fields[2], 10, 16)
+		if err != nil {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid SRV port in '%s': %w", rr.Data, err)
+		}
+		target, err := dnsmessage.NewName(ensureFQDN(fields[3]))
+		if err != nil {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid SRV target in '%s': %w", rr.Data, err)
+		}
+		body = &dnsmessage.SRVResource{Priority: uint16(priority), Weight: uint16(weight), Port: uint16(port), Target: target}
+	case dnsmessage.TypeSOA:
+		fields := strings.Fields(rr.Data)
+		if len(fields) != 7 {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid SOA data '%s'", rr.Data)
+		}
+		ns, err := dnsmessage.NewName(ensureFQDN(fields[0]))
+		if err != nil {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid SOA ns in '%s': %w", rr.Data, err)
+		}
+		mbox, err := dnsmessage.NewName(ensureFQDN(fields[1]))
+		if err != nil {
+			return dnsmessage.Resource{}, fmt.Errorf("invalid SOA mbox in '%s': %w", rr.Data, err)
+		}
+		nums := make([]uint64, 5)
+		for i, f := range fields[2:] {
+			nums[i], err = strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				return dnsmessage.Resource{}, fmt.Errorf("invalid SOA numeric field in '%s': %w", rr.Data, err)
 			}
+		}
+		body = &dnsmessage.SOAResource{
+			NS:      ns,
+			MBox:    mbox,
+			Serial:  uint32(nums[0]),
+			Refresh: uint32(nums[1]),
+			Retry:   uint32(nums[2]),
+			Expire:  uint32(nums[3]),
+			MinTTL:  uint32(nums[4]),
+		}
+	default:
+		return dnsmessage.Resource{}, fmt.Errorf("unsupported record type %d for JSON conversion", rr.Type)
+	}
+
+	return dnsmessage.Resource{Header: header, Body: body}, nil
+}
+
+// unknownTypeName为dnsmessage包未定义专用Resource结构体的记录类型
+// 返回一个便于阅读的名称。目前仅特化CAA（RFC 6844，类型号257），
+// 其余回退到Type.String()。
+func unknownTypeName(t dnsmessage.Type) string {
+	if t == 257 {
+		return "CAA"
+	}
+	return t.String()
+}
+
+// formatOPTOptions将OPT伪记录携带的EDNS选项渲染为可读字符串。
+func formatOPTOptions(options []dnsmessage.Option) string {
+	if len(options) == 0 {
+		return "(no options)"
+	}
+	parts := make([]string, 0, len(options))
+	for _, opt := range options {
+		parts = append(parts, fmt.Sprintf("code=%d len=%d data=%s", opt.Code, len(opt.Data), hex.EncodeToString(opt.Data)))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// formatRR将一条资源记录渲染为一行dig风格的文本：记录类型后跟RDATA。
+// 对于dnsmessage包未建模的记录类型（如CAA），直接十六进制打印原始
+// RDATA，而不是报错或丢弃该记录。
+func formatRR(rr dnsmessage.Resource) string {
+	switch body := rr.Body.(type) {
+	case *dnsmessage.AResource:
+		return fmt.Sprintf("A\t%v.%v.%v.%v", body.A[0], body.A[1], body.A[2], body.A[3])
+	case *dnsmessage.AAAAResource:
+		return fmt.Sprintf("AAAA\t%x:%x:%x:%x:%x:%x:%x:%x",
+			body.AAAA[0:2], body.AAAA[2:4], body.AAAA[4:6], body.AAAA[6:8],
+			body.AAAA[8:10], body.AAAA[10:12], body.AAAA[12:14], body.AAAA[14:16])
+	case *dnsmessage.CNAMEResource:
+		return fmt.Sprintf("CNAME\t%s", body.CNAME.String())
+	case *dnsmessage.NSResource:
+		return fmt.Sprintf("NS\t%s", body.NS.String())
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("SOA\t%s %s %d %d %d %d %d",
+			body.NS.String(), body.MBox.String(), body.Serial, body.Refresh, body.Retry, body.Expire, body.MinTTL)
+	case *dnsmessage.PTRResource:
+		return fmt.Sprintf("PTR\t%s", body.PTR.String())
+	case *dnsmessage.TXTResource:
+		return fmt.Sprintf("TXT\t%q", strings.Join(body.TXT, " "))
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("SRV\t%d %d %d %s", body.Priority, body.Weight, body.Port, body.Target.String())
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("MX\t%d %s", body.Pref, body.MX.String())
+	case *dnsmessage.OPTResource:
+		return fmt.Sprintf("OPT\t%s", formatOPTOptions(body.Options))
+	case *dnsmessage.UnknownResource:
+		return fmt.Sprintf("%s\t%s", unknownTypeName(rr.Header.Type), hex.EncodeToString(body.Data))
+	default:
+		return fmt.Sprintf("%s\t<unhandled Go type %T>", rr.Header.Type.String(), body)
+	}
+}
+
+// printDigReport以类似dig的格式打印响应：头部标志位、RCODE、各分区
+// 记录数，以及Answer/Authority/Additional三个分区的内容。
+func printDigReport(resp *dnsmessage.Message) {
+	h := resp.Header
+	fmt.Printf(";; ->>HEADER<<- opcode: %d, status: %s, id: %d\n", h.OpCode, h.RCode.String(), h.ID)
+
+	flags := []string{}
+	if h.Response {
+		flags = append(flags, "qr")
+	}
+	if h.Authoritative {
+		flags = append(flags, "aa")
+	}
+	if h.Truncated {
+		flags = append(flags, "tc")
+	}
+	if h.RecursionDesired {
+		flags = append(flags, "rd")
+	}
+	if h.RecursionAvailable {
+		flags = append(flags, "ra")
+	}
+	if h.AuthenticData {
+		flags = append(flags, "ad")
+	}
+	if h.CheckingDisabled {
+		flags = append(flags, "cd")
+	}
+	fmt.Printf(";; flags: %s; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d\n",
+		strings.Join(flags, " "), len(resp.Questions), len(resp.Answers), len(resp.Authorities), len(resp.Additionals))
+
+	if len(resp.Answers) > 0 {
+		fmt.Println("\n;; ANSWER SECTION:")
+		for _, rr := range resp.Answers {
+			fmt.Println(formatRR(rr))
+		}
+	}
+	if len(resp.Authorities) > 0 {
+		fmt.Println("\n;; AUTHORITY SECTION:")
+		for _, rr := range resp.Authorities {
+			fmt.Println(formatRR(rr))
+		}
+	}
+	if len(resp.Additionals) > 0 {
+		fmt.Println("\n;; ADDITIONAL SECTION:")
+		for _, rr := range resp.Additionals {
+			fmt.Println(formatRR(rr))
+		}
+	}
+}
+
+// queryDoHAll依次为每个qtype发起一次查询，并以dig风格打印结果，
+// 从而把本工具从单记录类型演示变成一个可用的诊断工具。单个qtype
+// 查询失败只记录日志并继续；只有全部失败时才返回错误。
+func queryDoHAll(serverURL string, domainName string, qtypes []dnsmessage.Type, method string, timeout time.Duration, tlsConfig *tls.Config, useJSON bool, edns *ednsOpts, useHTTP3 bool) error {
+	succeeded := 0
+	for _, qtype := range qtypes {
+		var resp *dnsmessage.Message
+		var err error
+		if useJSON {
+			resp, err = queryDoHJSON(serverURL, domainName, qtype, timeout, tlsConfig)
 		} else {
-			fmt.Println("No MX records found.")
+			resp, err = queryDoHWithTransport(serverURL, domainName, qtype, method, timeout, tlsConfig, edns, useHTTP3)
 		}
+		if err != nil {
+			log.Printf("query %s %s failed: %v\n", qtype.String(), domainName, err)
+			continue
+		}
+		fmt.Printf("\n; <<>> %s %s %s <<>>\n", strings.ToUpper(method), domainName, qtype.String())
+		printDigReport(resp)
+		succeeded++
+	}
+	if succeeded == 0 {
+		return fmt.Errorf("all %d quer(ies) failed for %s", len(qtypes), domainName)
 	}
+	return nil
+}
+
+// --- 命令行入口 ---
+func main() {
+	serverURL := flag.String("server", "http://localhost:8080/dns-query", "DoH server URL")
+	qname := flag.String("qname", "www.example.com", "domain name to query")
+	qtypeName := flag.String("qtype", "A", "comma-separated query types (A, AAAA, MX, CNAME, NS, TXT)")
+	method := flag.String("method", "POST", "DoH request method: GET or POST")
+	useJSON := flag.Bool("json", false, "use the Google/Cloudflare JSON DoH dialect instead of wire format")
+	timeout := flag.Duration("timeout", 10*time.Second, "HTTP request timeout")
+	insecure := flag.Bool("insecure", false, "skip TLS certificate verification (opt-in; dangerous)")
+	caFile := flag.String("ca-file", "", "PEM file with custom CA certificates to trust")
+	pinSHA256 := flag.String("pin-sha256", "", "comma-separated base64 SHA-256 SPKI pins; handshake fails unless one matches")
+	serverName := flag.String("server-name", "", "TLS ServerName (SNI) to present; defaults to the server URL's host")
+	edns := flag.Bool("edns", false, "attach an EDNS0 OPT pseudo-RR to the query")
+	ednsSize := flag.Uint("edns-size", 1232, "advertised UDP payload size for the EDNS0 OPT record")
+	dnssecOK := flag.Bool("dnssec", false, "set the EDNS0 DO bit and log the response's AD status")
+	clientSubnet := flag.String("client-subnet", "", "EDNS Client Subnet to send, e.g. 203.0.113.0/24 (RFC 7871)")
+	http3Flag := flag.Bool("http3", false, "use DoH3 (RFC 9230) over HTTP/3, falling back to HTTP/2 on failure")
+	flag.Parse()
 
-	// --- 查询一个不存在的域名 ---
-	fmt.Printf("\n--- Querying Non-existent Domain (nonexistent-domain-askljhfdsa.com) ---\n")
-	nxDomain := "nonexistent-domain-askljhfdsa.com"
-	nxResponse, err := queryDoH(dohServer, nxDomain, dnsmessage.TypeA)
+	qtypes, err := parseQTypes(*qtypeName)
 	if err != nil {
-		log.Printf("Failed to query %s: %v\n", nxDomain, err)
-	} else {
-		fmt.Println("Response Code:", nxResponse.Header.RCode.String())
-		fmt.Println("Parsed Results (Answer Section):")
-		if len(nxResponse.Answers) > 0 {
-			for _, answer := range nxResponse.Answers {
-				switch rr := answer.Body.(type) {
-				case *dnsmessage.AResource:
-					fmt.Printf("A Record: %v.%v.%v.%v\n", rr.A[0], rr.A[1], rr.A[2], rr.A[3])
-				default:
-					fmt.Printf("Unknown record type: %T\n", rr)
-				}
+		log.Fatalf("invalid -qtype: %v", err)
+	}
+
+	var ednsOptions *ednsOpts
+	if *edns || *dnssecOK || *clientSubnet != "" {
+		ednsOptions = &ednsOpts{UDPSize: uint16(*ednsSize), DNSSECOK: *dnssecOK}
+		if *clientSubnet != "" {
+			_, subnet, err := net.ParseCIDR(*clientSubnet)
+			if err != nil {
+				log.Fatalf("invalid -client-subnet: %v", err)
 			}
-		} else {
-			fmt.Println("No records found.")
+			ednsOptions.ClientSubnet = subnet
+		}
+	}
+
+	var pins []string
+	if *pinSHA256 != "" {
+		for _, pin := range strings.Split(*pinSHA256, ",") {
+			pins = append(pins, strings.TrimSpace(pin))
 		}
 	}
+	sni := *serverName
+	if sni == "" {
+		if parsedURL, err := url.Parse(*serverURL); err == nil {
+			sni = parsedURL.Hostname()
+		}
+	}
+	tlsConfig, err := buildTLSConfig(sni, *caFile, pins, *insecure)
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %v", err)
+	}
+
+	if err := queryDoHAll(*serverURL, *qname, qtypes, *method, *timeout, tlsConfig, *useJSON, ednsOptions, *http3Flag); err != nil {
+		log.Fatalf("%v", err)
+	}
 }
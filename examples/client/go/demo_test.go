@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// TestQueryDoHJSONSendsMnemonicType防止"type"查询参数回归为
+// dnsmessage.Type.String()返回的Go常量名（如"TypeA"）：Cloudflare和
+// Google只认识"A"这样的RR助记符，或者数字形式的类型号。
+func TestQueryDoHJSONSendsMnemonicType(t *testing.T) {
+	var gotType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotType = r.URL.Query().Get("type")
+		w.Header().Set("Content-Type", "application/dns-json")
+		_ = json.NewEncoder(w).Encode(dohJSONResponse{
+			Status:   0,
+			RD:       true,
+			RA:       true,
+			Question: []dohJSONQuestion{{Name: "example.com.", Type: 1}},
+			Answer:   []dohJSONRR{{Name: "example.com.", Type: 1, TTL: 300, Data: "93.184.216.34"}},
+		})
+	}))
+	defer server.Close()
+
+	msg, err := queryDoHJSON(server.URL, "example.com", dnsmessage.TypeA, 5*time.Second, nil)
+	if err != nil {
+		t.Fatalf("queryDoHJSON returned error: %v", err)
+	}
+	if gotType != "A" {
+		t.Fatalf("expected type query param %q, got %q", "A", gotType)
+	}
+	if len(msg.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(msg.Answers))
+	}
+	if _, ok := msg.Answers[0].Body.(*dnsmessage.AResource); !ok {
+		t.Fatalf("expected *dnsmessage.AResource, got %T", msg.Answers[0].Body)
+	}
+}
+
+// TestQtypeMnemonic验证已知类型映射到RR助记符，未知类型回退到数字。
+func TestQtypeMnemonic(t *testing.T) {
+	if got := qtypeMnemonic(dnsmessage.TypeMX); got != "MX" {
+		t.Fatalf("qtypeMnemonic(TypeMX) = %q, want %q", got, "MX")
+	}
+	if got := qtypeMnemonic(dnsmessage.Type(257)); got != "257" {
+		t.Fatalf("qtypeMnemonic(257) = %q, want %q", got, "257")
+	}
+}
+
+// spkiPin计算一张证书的SPKI SHA-256摘要，并编码为buildTLSConfig期望
+// 的base64形式。
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// TestBuildTLSConfigSPKIPinning针对buildTLSConfig安装的
+// VerifyPeerCertificate回调做三组验证：pin匹配且insecure=true时握手
+// 成功；pin不匹配且insecure=true时握手失败；以及pin匹配但
+// insecure=false、又没有配置信任该自签名证书的CA时依然握手失败——
+// 这证明SPKI pinning只是在正常证书链校验之上叠加的额外约束，而不是
+// 替代它。任何把VerifyPeerCertificate错误地改成无条件返回nil，或者
+// pin比较逻辑恒真的回归，都会被这组测试捕获。
+func TestBuildTLSConfigSPKIPinning(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	correctPin := spkiPin(server.Certificate())
+	var zeroSum [sha256.Size]byte
+	wrongPin := base64.StdEncoding.EncodeToString(zeroSum[:])
+
+	t.Run("matching pin with insecure succeeds", func(t *testing.T) {
+		tlsConfig, err := buildTLSConfig("", "", []string{correctPin}, true)
+		if err != nil {
+			t.Fatalf("buildTLSConfig returned error: %v", err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("expected request to succeed with a matching pin, got error: %v", err)
+		}
+		resp.Body.Close()
+	})
+
+	t.Run("mismatched pin with insecure fails", func(t *testing.T) {
+		tlsConfig, err := buildTLSConfig("", "", []string{wrongPin}, true)
+		if err != nil {
+			t.Fatalf("buildTLSConfig returned error: %v", err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		if _, err := client.Get(server.URL); err == nil {
+			t.Fatal("expected handshake failure for a mismatched pin, got nil error")
+		}
+	})
+
+	t.Run("matching pin without trusted CA still fails chain verification", func(t *testing.T) {
+		tlsConfig, err := buildTLSConfig("", "", []string{correctPin}, false)
+		if err != nil {
+			t.Fatalf("buildTLSConfig returned error: %v", err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		if _, err := client.Get(server.URL); err == nil {
+			t.Fatal("expected chain verification failure despite a matching pin, got nil error")
+		}
+	})
+}